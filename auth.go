@@ -0,0 +1,263 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+//
+// Signed, expiring bearer tokens and role-based authorization for the display HTTP and websocket
+// handlers, so that arbitrary clients on the arena LAN can't mutate live match state.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Role identifies what a bearer token is allowed to do.
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleReferee    Role = "referee"
+	RoleHeadRef    Role = "head-ref"
+	RoleRedRef     Role = "red-ref"
+	RoleBlueRef    Role = "blue-ref"
+	RoleScorerRed  Role = "scorer-red"
+	RoleScorerBlue Role = "scorer-blue"
+	RoleAnnouncer  Role = "announcer"
+	RoleReadonly   Role = "readonly"
+)
+
+// AuthToken is the decoded, verified payload of a bearer token.
+type AuthToken struct {
+	Id        string
+	Role      Role
+	ExpiresAt time.Time
+}
+
+// tokenPayload is the JSON shape that gets base64-encoded and HMAC-signed to form a token string.
+type tokenPayload struct {
+	Id        string
+	Role      Role
+	ExpiresAt int64
+}
+
+// revokedTokens tracks the Ids of tokens that have been revoked before their natural expiry.
+var revokedTokens = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: make(map[string]bool)}
+
+// IssueToken creates a new signed bearer token carrying the given role, valid for validFor.
+func IssueToken(role Role, validFor time.Duration) (string, error) {
+	if err := requireSigningSecret(); err != nil {
+		return "", err
+	}
+	payload := tokenPayload{
+		Id:        fmt.Sprintf("%d", time.Now().UnixNano()),
+		Role:      role,
+		ExpiresAt: time.Now().Add(validFor).Unix(),
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payloadBytes)
+	signature := signPayload(encodedPayload)
+	return encodedPayload + "." + signature, nil
+}
+
+// RevokeToken marks a previously-issued token as no longer valid, regardless of its expiry.
+func RevokeToken(token string) error {
+	authToken, err := ValidateToken(token)
+	if err != nil {
+		return err
+	}
+	revokedTokens.Lock()
+	defer revokedTokens.Unlock()
+	revokedTokens.ids[authToken.Id] = true
+	return nil
+}
+
+// ValidateToken checks the signature and expiry of a token string and returns its decoded payload.
+func ValidateToken(token string) (*AuthToken, error) {
+	if err := requireSigningSecret(); err != nil {
+		return nil, err
+	}
+	parts := splitToken(token)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	encodedPayload, signature := parts[0], parts[1]
+	if !hmac.Equal([]byte(signature), []byte(signPayload(encodedPayload))) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token encoding")
+	}
+	var payload tokenPayload
+	if err = json.Unmarshal(payloadBytes, &payload); err != nil {
+		return nil, fmt.Errorf("invalid token payload")
+	}
+	expiresAt := time.Unix(payload.ExpiresAt, 0)
+	if time.Now().After(expiresAt) {
+		return nil, fmt.Errorf("token has expired")
+	}
+	revokedTokens.Lock()
+	revoked := revokedTokens.ids[payload.Id]
+	revokedTokens.Unlock()
+	if revoked {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+	return &AuthToken{Id: payload.Id, Role: payload.Role, ExpiresAt: expiresAt}, nil
+}
+
+// requireSigningSecret fails closed if eventSettings.TokenSigningSecret hasn't been configured,
+// instead of letting IssueToken/ValidateToken silently HMAC-sign with an empty key -- which would
+// make every token trivially forgeable by anyone who can compute HMAC-SHA256("", payload).
+func requireSigningSecret() error {
+	if eventSettings == nil || eventSettings.TokenSigningSecret == "" {
+		return fmt.Errorf("no token signing secret is configured for this event")
+	}
+	return nil
+}
+
+// signPayload HMAC-signs the base64-encoded payload using the event's persisted secret.
+func signPayload(encodedPayload string) string {
+	mac := hmac.New(sha256.New, []byte(eventSettings.TokenSigningSecret))
+	mac.Write([]byte(encodedPayload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// splitToken splits a "payload.signature" token string into its two parts.
+func splitToken(token string) []string {
+	for i := len(token) - 1; i >= 0; i-- {
+		if token[i] == '.' {
+			return []string{token[:i], token[i+1:]}
+		}
+	}
+	return []string{token}
+}
+
+// scorerRoleForAlliance returns the role required to operate the scoring interface for the given
+// alliance ("red" or "blue").
+func scorerRoleForAlliance(alliance string) Role {
+	if alliance == "red" {
+		return RoleScorerRed
+	}
+	return RoleScorerBlue
+}
+
+// authorizeRequest extracts and validates the bearer token from the request's "token" query
+// parameter (used by the display tablets rather than an Authorization header, for easy embedding in
+// a bookmarked URL) and checks that its role is one of allowedRoles. It writes an HTTP error and
+// returns false if authorization fails.
+func authorizeRequest(w http.ResponseWriter, r *http.Request, allowedRoles ...Role) (*AuthToken, bool) {
+	tokenString := r.URL.Query().Get("token")
+	authToken, err := ValidateToken(tokenString)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unauthorized: %s", err), http.StatusUnauthorized)
+		return nil, false
+	}
+	for _, allowedRole := range allowedRoles {
+		if subtle.ConstantTimeCompare([]byte(authToken.Role), []byte(allowedRole)) == 1 {
+			return authToken, true
+		}
+	}
+	http.Error(w, fmt.Sprintf("Role '%s' is not permitted to access this display.", authToken.Role),
+		http.StatusForbidden)
+	return nil, false
+}
+
+// requireRole wraps an HTTP handler so that it only runs if the request carries a valid bearer token
+// for one of allowedRoles.
+func requireRole(handler http.HandlerFunc, allowedRoles ...Role) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authorizeRequest(w, r, allowedRoles...); !ok {
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// auditFoulAction logs which token issued a foul-affecting command, for after-the-fact review of who
+// committed a match or added/removed a given foul.
+func auditFoulAction(authToken *AuthToken, action, alliance string, teamId int, rule string) {
+	log.Printf("AUDIT: token=%s role=%s action=%s alliance=%s team=%d rule=%s", authToken.Id, authToken.Role,
+		action, alliance, teamId, rule)
+}
+
+// AdminIssueTokenHandler lets an event admin mint a token for a given non-admin role, valid for a
+// fixed 12 hours, for embedding in a display URL as "?token=..." so a tablet can be bookmarked without
+// re-authenticating every match. Since this endpoint can mint a token for any referee, scorer,
+// announcer or readonly role, it's gated behind RoleAdmin rather than left open to anyone who can
+// reach the route.
+func AdminIssueTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeRequest(w, r, RoleAdmin); !ok {
+		return
+	}
+
+	role := Role(r.PostFormValue("role"))
+	switch role {
+	case RoleReferee, RoleHeadRef, RoleRedRef, RoleBlueRef, RoleScorerRed, RoleScorerBlue, RoleAnnouncer, RoleReadonly:
+	default:
+		http.Error(w, fmt.Sprintf("Invalid role '%s'.", role), http.StatusBadRequest)
+		return
+	}
+
+	validFor := 12 * time.Hour
+	token, err := IssueToken(role, validFor)
+	if err != nil {
+		handleWebErr(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(struct {
+		Token string
+		Role  Role
+	}{token, role})
+}
+
+// AdminRevokeTokenHandler lets an event admin immediately invalidate a previously-issued token, e.g.
+// after a tablet is lost or a referee is swapped out mid-event, rather than waiting for it to expire.
+func AdminRevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeRequest(w, r, RoleAdmin); !ok {
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if err := RevokeToken(token); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to revoke token: %s", err), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// BootstrapAdminToken mints a long-lived RoleAdmin token without requiring an existing one, and logs
+// it so an operator can read it from the server's console/log output. Without this there would be no
+// way to ever obtain the very first admin token, since AdminIssueTokenHandler itself requires
+// RoleAdmin to mint any token at all. Call this once from the startup path, after
+// eventSettings.TokenSigningSecret has been loaded -- it's not wired to any route, since minting an
+// admin token over HTTP with no prior credential would defeat the point of requiring one.
+func BootstrapAdminToken() (string, error) {
+	token, err := IssueToken(RoleAdmin, 365*24*time.Hour)
+	if err != nil {
+		return "", fmt.Errorf("failed to bootstrap admin token: %s", err)
+	}
+	log.Printf("Bootstrap admin token (save this, it won't be logged again): %s", token)
+	return token, nil
+}
+
+// RegisterAuthRoutes wires this file's handlers into router. Called from the same startup path that
+// registers the rest of the display routes.
+func RegisterAuthRoutes(router *mux.Router) {
+	router.HandleFunc("/api/tokens", AdminIssueTokenHandler).Methods("POST")
+	router.HandleFunc("/api/tokens/revoke", AdminRevokeTokenHandler).Methods("POST")
+}
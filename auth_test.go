@@ -0,0 +1,74 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIssueAndValidateToken(t *testing.T) {
+	eventSettings = &EventSettings{TokenSigningSecret: "test-secret"}
+
+	token, err := IssueToken(RoleHeadRef, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken returned an error: %s", err)
+	}
+
+	authToken, err := ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken returned an error for a freshly-issued token: %s", err)
+	}
+	if authToken.Role != RoleHeadRef {
+		t.Errorf("expected role %s, got %s", RoleHeadRef, authToken.Role)
+	}
+}
+
+func TestValidateTokenRejectsExpiredToken(t *testing.T) {
+	eventSettings = &EventSettings{TokenSigningSecret: "test-secret"}
+
+	token, err := IssueToken(RoleReadonly, -time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken returned an error: %s", err)
+	}
+	if _, err := ValidateToken(token); err == nil {
+		t.Error("expected an error for an already-expired token")
+	}
+}
+
+func TestValidateTokenRejectsTamperedSignature(t *testing.T) {
+	eventSettings = &EventSettings{TokenSigningSecret: "test-secret"}
+
+	token, err := IssueToken(RoleReadonly, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken returned an error: %s", err)
+	}
+	if _, err := ValidateToken(token + "x"); err == nil {
+		t.Error("expected an error for a token with a tampered signature")
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	eventSettings = &EventSettings{TokenSigningSecret: "test-secret"}
+
+	token, err := IssueToken(RoleAnnouncer, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken returned an error: %s", err)
+	}
+	if err := RevokeToken(token); err != nil {
+		t.Fatalf("RevokeToken returned an error: %s", err)
+	}
+	if _, err := ValidateToken(token); err == nil {
+		t.Error("expected a revoked token to fail validation even though it hasn't expired")
+	}
+}
+
+func TestScorerRoleForAlliance(t *testing.T) {
+	if role := scorerRoleForAlliance("red"); role != RoleScorerRed {
+		t.Errorf("expected %s, got %s", RoleScorerRed, role)
+	}
+	if role := scorerRoleForAlliance("blue"); role != RoleScorerBlue {
+		t.Errorf("expected %s, got %s", RoleScorerBlue, role)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/mitchellh/mapstructure"
 	"io"
-	"log"
 	"net/http"
 	"strconv"
 	"text/template"
@@ -39,6 +38,10 @@ func PitDisplayHandler(w http.ResponseWriter, r *http.Request) {
 
 // Renders the announcer display which shows team info and scores for the current match.
 func AnnouncerDisplayHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeRequest(w, r, RoleAnnouncer, RoleReadonly); !ok {
+		return
+	}
+
 	template := template.New("").Funcs(templateHelpers)
 	_, err := template.ParseFiles("templates/announcer_display.html", "templates/base.html")
 	if err != nil {
@@ -95,6 +98,10 @@ func AnnouncerDisplayHandler(w http.ResponseWriter, r *http.Request) {
 
 // The websocket endpoint for the announcer display client to send control commands and receive status updates.
 func AnnouncerDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeRequest(w, r, RoleAnnouncer, RoleReadonly); !ok {
+		return
+	}
+
 	websocket, err := NewWebsocket(w, r)
 	if err != nil {
 		handleWebErr(w, err)
@@ -109,16 +116,18 @@ func AnnouncerDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 	scorePostedListener := mainArena.scorePostedNotifier.Listen()
 	defer close(scorePostedListener)
 
+	seq := new(sequencer)
+
 	// Send the various notifications immediately upon connection.
-	err = websocket.Write("matchTiming", mainArena.matchTiming)
+	err = seq.writeEnvelope(websocket, "matchTiming", mainArena.matchTiming)
 	if err != nil {
-		log.Printf("Websocket error: %s", err)
+		writeCloseAndReturn(websocket, err)
 		return
 	}
 	data := MatchTimeMessage{mainArena.MatchState, int(mainArena.lastMatchTimeSec)}
-	err = websocket.Write("matchTime", data)
+	err = seq.writeEnvelope(websocket, "matchTime", data)
 	if err != nil {
-		log.Printf("Websocket error: %s", err)
+		writeCloseAndReturn(websocket, err)
 		return
 	}
 
@@ -147,7 +156,7 @@ func AnnouncerDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 				messageType = "reload"
 				message = nil
 			}
-			err = websocket.Write(messageType, message)
+			err = seq.writeEnvelope(websocket, messageType, message)
 			if err != nil {
 				// The client has probably closed the connection; nothing to do here.
 				return
@@ -163,14 +172,25 @@ func AnnouncerDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 				// Client has closed the connection; nothing to do here.
 				return
 			}
-			log.Printf("Websocket error: %s", err)
+			writeCloseAndReturn(websocket, err)
 			return
 		}
 
 		switch messageType {
+		case "resync":
+			// The client detected a sequence gap; resend the full current state.
+			if err = seq.writeEnvelope(websocket, "matchTiming", mainArena.matchTiming); err != nil {
+				writeCloseAndReturn(websocket, err)
+				return
+			}
+			data := MatchTimeMessage{mainArena.MatchState, int(mainArena.lastMatchTimeSec)}
+			if err = seq.writeEnvelope(websocket, "matchTime", data); err != nil {
+				writeCloseAndReturn(websocket, err)
+				return
+			}
 		default:
-			websocket.WriteError(fmt.Sprintf("Invalid message type '%s'.", messageType))
-			continue
+			writeCloseAndReturn(websocket, newProtocolError("Invalid message type '%s'.", messageType))
+			return
 		}
 	}
 }
@@ -183,6 +203,9 @@ func ScoringDisplayHandler(w http.ResponseWriter, r *http.Request) {
 		handleWebErr(w, fmt.Errorf("Invalid alliance '%s'.", alliance))
 		return
 	}
+	if _, ok := authorizeRequest(w, r, scorerRoleForAlliance(alliance), RoleReadonly); !ok {
+		return
+	}
 
 	template, err := template.ParseFiles("templates/scoring_display.html", "templates/base.html")
 	if err != nil {
@@ -208,12 +231,10 @@ func ScoringDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 		handleWebErr(w, fmt.Errorf("Invalid alliance '%s'.", alliance))
 		return
 	}
-	var score **RealtimeScore
-	if alliance == "red" {
-		score = &mainArena.redRealtimeScore
-	} else {
-		score = &mainArena.blueRealtimeScore
+	if _, ok := authorizeRequest(w, r, scorerRoleForAlliance(alliance)); !ok {
+		return
 	}
+	realtimeScore := allianceRealtimeScore(alliance)
 
 	websocket, err := NewWebsocket(w, r)
 	if err != nil {
@@ -225,10 +246,12 @@ func ScoringDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 	matchLoadTeamsListener := mainArena.matchLoadTeamsNotifier.Listen()
 	defer close(matchLoadTeamsListener)
 
+	seq := new(sequencer)
+
 	// Send the various notifications immediately upon connection.
-	err = websocket.Write("score", *score)
+	err = seq.writeEnvelope(websocket, "score", realtimeScore.WithReadLock())
 	if err != nil {
-		log.Printf("Websocket error: %s", err)
+		writeCloseAndReturn(websocket, err)
 		return
 	}
 
@@ -243,9 +266,9 @@ func ScoringDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 				messageType = "score"
-				message = *score
+				message = realtimeScore.WithReadLock()
 			}
-			err = websocket.Write(messageType, message)
+			err = seq.writeEnvelope(websocket, messageType, message)
 			if err != nil {
 				// The client has probably closed the connection; nothing to do here.
 				return
@@ -261,131 +284,147 @@ func ScoringDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 				// Client has closed the connection; nothing to do here.
 				return
 			}
-			log.Printf("Websocket error: %s", err)
+			writeCloseAndReturn(websocket, err)
 			return
 		}
 
-		switch messageType {
-		case "preload":
-			if !(*score).AutoCommitted {
-				preloadedBallsStr, ok := data.(string)
-				if !ok {
-					websocket.WriteError(fmt.Sprintf("Failed to parse '%s' message.", messageType))
-					continue
+		if messageType == "resync" {
+			if err = seq.writeEnvelope(websocket, "score", realtimeScore.WithReadLock()); err != nil {
+				writeCloseAndReturn(websocket, err)
+				return
+			}
+			continue
+		}
+
+		// Every mutation below runs under the realtime score's write lock so that a referee goroutine
+		// assigning a foul can never interleave with a scorekeeper committing a cycle.
+		var parseErr error
+		snapshot := realtimeScore.WithWriteLock(func(score *RealtimeScore) {
+			switch messageType {
+			case "preload":
+				if !score.AutoCommitted {
+					preloadedBallsStr, ok := data.(string)
+					if !ok {
+						parseErr = newProtocolError("Failed to parse '%s' message.", messageType)
+						return
+					}
+					preloadedBalls, err := strconv.Atoi(preloadedBallsStr)
+					if err != nil {
+						parseErr = newProtocolError("Failed to parse '%s' message.", messageType)
+						return
+					}
+					score.AutoPreloadedBalls = preloadedBalls
 				}
-				preloadedBalls, err := strconv.Atoi(preloadedBallsStr)
-				(*score).AutoPreloadedBalls = preloadedBalls
-				if err != nil {
-					websocket.WriteError(fmt.Sprintf("Failed to parse '%s' message.", messageType))
-					continue
+			case "mobility":
+				if !score.AutoCommitted {
+					score.undoAutoScores = append(score.undoAutoScores, score.CurrentScore)
+					score.CurrentScore.AutoMobilityBonuses += 1
 				}
-			}
-		case "mobility":
-			if !(*score).AutoCommitted {
-				(*score).undoAutoScores = append((*score).undoAutoScores, (*score).CurrentScore)
-				(*score).CurrentScore.AutoMobilityBonuses += 1
-			}
-		case "scoredHighHot":
-			if !(*score).AutoCommitted {
-				(*score).undoAutoScores = append((*score).undoAutoScores, (*score).CurrentScore)
-				(*score).CurrentScore.AutoHighHot += 1
-			}
-		case "scoredHigh":
-			if !(*score).AutoCommitted {
-				(*score).undoAutoScores = append((*score).undoAutoScores, (*score).CurrentScore)
-				(*score).CurrentScore.AutoHigh += 1
-			} else if !(*score).TeleopCommitted && !(*score).CurrentCycle.ScoredHigh {
-				(*score).undoCycles = append((*score).undoCycles, (*score).CurrentCycle)
-				(*score).CurrentCycle.ScoredHigh = true
-				(*score).CurrentCycle.ScoredLow = false
-				(*score).CurrentCycle.DeadBall = false
-			}
-		case "scoredLowHot":
-			if !(*score).AutoCommitted {
-				(*score).undoAutoScores = append((*score).undoAutoScores, (*score).CurrentScore)
-				(*score).CurrentScore.AutoLowHot += 1
-			}
-		case "scoredLow":
-			if !(*score).AutoCommitted {
-				(*score).undoAutoScores = append((*score).undoAutoScores, (*score).CurrentScore)
-				(*score).CurrentScore.AutoLow += 1
-			} else if !(*score).TeleopCommitted && !(*score).CurrentCycle.ScoredLow {
-				(*score).undoCycles = append((*score).undoCycles, (*score).CurrentCycle)
-				(*score).CurrentCycle.ScoredHigh = false
-				(*score).CurrentCycle.ScoredLow = true
-				(*score).CurrentCycle.DeadBall = false
-			}
-		case "assist":
-			if !(*score).TeleopCommitted && (*score).CurrentCycle.Assists < 3 {
-				(*score).undoCycles = append((*score).undoCycles, (*score).CurrentCycle)
-				(*score).CurrentCycle.Assists += 1
-			}
-		case "truss":
-			if !(*score).TeleopCommitted && !(*score).CurrentCycle.Truss {
-				(*score).undoCycles = append((*score).undoCycles, (*score).CurrentCycle)
-				(*score).CurrentCycle.Truss = true
-			}
-		case "catch":
-			if !(*score).TeleopCommitted && !(*score).CurrentCycle.Catch && (*score).CurrentCycle.Truss {
-				(*score).undoCycles = append((*score).undoCycles, (*score).CurrentCycle)
-				(*score).CurrentCycle.Catch = true
-			}
-		case "deadBall":
-			if !(*score).TeleopCommitted && !(*score).CurrentCycle.DeadBall {
-				(*score).undoCycles = append((*score).undoCycles, (*score).CurrentCycle)
-				(*score).CurrentCycle.ScoredHigh = false
-				(*score).CurrentCycle.ScoredLow = false
-				(*score).CurrentCycle.DeadBall = true
-			}
-		case "commit":
-			if !(*score).AutoCommitted {
-				(*score).AutoCommitted = true
-			} else if !(*score).TeleopCommitted {
-				if (*score).CurrentCycle.ScoredHigh || (*score).CurrentCycle.ScoredLow ||
-					(*score).CurrentCycle.DeadBall {
-					// Check whether this is a leftover ball from autonomous.
-					if ((*score).AutoPreloadedBalls - (*score).CurrentScore.AutoHighHot -
-						(*score).CurrentScore.AutoHigh - (*score).CurrentScore.AutoLowHot -
-						(*score).CurrentScore.AutoLow - (*score).CurrentScore.AutoClearHigh -
-						(*score).CurrentScore.AutoClearLow - (*score).CurrentScore.AutoClearDead) > 0 {
-						if (*score).CurrentCycle.ScoredHigh {
-							(*score).CurrentScore.AutoClearHigh += 1
-						} else if (*score).CurrentCycle.ScoredLow {
-							(*score).CurrentScore.AutoClearLow += 1
+			case "scoredHighHot":
+				if !score.AutoCommitted {
+					score.undoAutoScores = append(score.undoAutoScores, score.CurrentScore)
+					score.CurrentScore.AutoHighHot += 1
+				}
+			case "scoredHigh":
+				if !score.AutoCommitted {
+					score.undoAutoScores = append(score.undoAutoScores, score.CurrentScore)
+					score.CurrentScore.AutoHigh += 1
+				} else if !score.TeleopCommitted && !score.CurrentCycle.ScoredHigh {
+					score.undoCycles = append(score.undoCycles, score.CurrentCycle)
+					score.CurrentCycle.ScoredHigh = true
+					score.CurrentCycle.ScoredLow = false
+					score.CurrentCycle.DeadBall = false
+				}
+			case "scoredLowHot":
+				if !score.AutoCommitted {
+					score.undoAutoScores = append(score.undoAutoScores, score.CurrentScore)
+					score.CurrentScore.AutoLowHot += 1
+				}
+			case "scoredLow":
+				if !score.AutoCommitted {
+					score.undoAutoScores = append(score.undoAutoScores, score.CurrentScore)
+					score.CurrentScore.AutoLow += 1
+				} else if !score.TeleopCommitted && !score.CurrentCycle.ScoredLow {
+					score.undoCycles = append(score.undoCycles, score.CurrentCycle)
+					score.CurrentCycle.ScoredHigh = false
+					score.CurrentCycle.ScoredLow = true
+					score.CurrentCycle.DeadBall = false
+				}
+			case "assist":
+				if !score.TeleopCommitted && score.CurrentCycle.Assists < 3 {
+					score.undoCycles = append(score.undoCycles, score.CurrentCycle)
+					score.CurrentCycle.Assists += 1
+				}
+			case "truss":
+				if !score.TeleopCommitted && !score.CurrentCycle.Truss {
+					score.undoCycles = append(score.undoCycles, score.CurrentCycle)
+					score.CurrentCycle.Truss = true
+				}
+			case "catch":
+				if !score.TeleopCommitted && !score.CurrentCycle.Catch && score.CurrentCycle.Truss {
+					score.undoCycles = append(score.undoCycles, score.CurrentCycle)
+					score.CurrentCycle.Catch = true
+				}
+			case "deadBall":
+				if !score.TeleopCommitted && !score.CurrentCycle.DeadBall {
+					score.undoCycles = append(score.undoCycles, score.CurrentCycle)
+					score.CurrentCycle.ScoredHigh = false
+					score.CurrentCycle.ScoredLow = false
+					score.CurrentCycle.DeadBall = true
+				}
+			case "commit":
+				if !score.AutoCommitted {
+					score.AutoCommitted = true
+				} else if !score.TeleopCommitted {
+					if score.CurrentCycle.ScoredHigh || score.CurrentCycle.ScoredLow ||
+						score.CurrentCycle.DeadBall {
+						// Check whether this is a leftover ball from autonomous.
+						if (score.AutoPreloadedBalls - score.CurrentScore.AutoHighHot -
+							score.CurrentScore.AutoHigh - score.CurrentScore.AutoLowHot -
+							score.CurrentScore.AutoLow - score.CurrentScore.AutoClearHigh -
+							score.CurrentScore.AutoClearLow - score.CurrentScore.AutoClearDead) > 0 {
+							if score.CurrentCycle.ScoredHigh {
+								score.CurrentScore.AutoClearHigh += 1
+							} else if score.CurrentCycle.ScoredLow {
+								score.CurrentScore.AutoClearLow += 1
+							} else {
+								score.CurrentScore.AutoClearDead += 1
+							}
 						} else {
-							(*score).CurrentScore.AutoClearDead += 1
+							score.CurrentScore.Cycles = append(score.CurrentScore.Cycles, score.CurrentCycle)
 						}
-					} else {
-						(*score).CurrentScore.Cycles = append((*score).CurrentScore.Cycles, (*score).CurrentCycle)
+						score.CurrentCycle = Cycle{}
+						score.undoCycles = []Cycle{}
 					}
-					(*score).CurrentCycle = Cycle{}
-					(*score).undoCycles = []Cycle{}
 				}
+			case "commitMatch":
+				score.AutoCommitted = true
+				score.TeleopCommitted = true
+				if score.CurrentCycle != (Cycle{}) {
+					// Commit last cycle.
+					score.CurrentScore.Cycles = append(score.CurrentScore.Cycles, score.CurrentCycle)
+				}
+			case "undo":
+				if !score.AutoCommitted && len(score.undoAutoScores) > 0 {
+					score.CurrentScore = score.undoAutoScores[len(score.undoAutoScores)-1]
+					score.undoAutoScores = score.undoAutoScores[0 : len(score.undoAutoScores)-1]
+				} else if !score.TeleopCommitted && len(score.undoCycles) > 0 {
+					score.CurrentCycle = score.undoCycles[len(score.undoCycles)-1]
+					score.undoCycles = score.undoCycles[0 : len(score.undoCycles)-1]
+				}
+			default:
+				parseErr = newProtocolError("Invalid message type '%s'.", messageType)
 			}
-		case "commitMatch":
-			(*score).AutoCommitted = true
-			(*score).TeleopCommitted = true
-			if (*score).CurrentCycle != (Cycle{}) {
-				// Commit last cycle.
-				(*score).CurrentScore.Cycles = append((*score).CurrentScore.Cycles, (*score).CurrentCycle)
-			}
-		case "undo":
-			if !(*score).AutoCommitted && len((*score).undoAutoScores) > 0 {
-				(*score).CurrentScore = (*score).undoAutoScores[len((*score).undoAutoScores)-1]
-				(*score).undoAutoScores = (*score).undoAutoScores[0 : len((*score).undoAutoScores)-1]
-			} else if !(*score).TeleopCommitted && len((*score).undoCycles) > 0 {
-				(*score).CurrentCycle = (*score).undoCycles[len((*score).undoCycles)-1]
-				(*score).undoCycles = (*score).undoCycles[0 : len((*score).undoCycles)-1]
-			}
-		default:
-			websocket.WriteError(fmt.Sprintf("Invalid message type '%s'.", messageType))
-			continue
+		})
+		if parseErr != nil {
+			writeCloseAndReturn(websocket, parseErr)
+			return
 		}
 
 		// Send out the score again after handling the command, as it most likely changed as a result.
-		err = websocket.Write("score", *score)
+		err = seq.writeEnvelope(websocket, "score", snapshot)
 		if err != nil {
-			log.Printf("Websocket error: %s", err)
+			writeCloseAndReturn(websocket, err)
 			return
 		}
 	}
@@ -393,6 +432,10 @@ func ScoringDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 
 // Renders the referee interface for assigning fouls.
 func RefereeDisplayHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeRequest(w, r, RoleHeadRef, RoleRedRef, RoleBlueRef); !ok {
+		return
+	}
+
 	template := template.New("").Funcs(templateHelpers)
 	_, err := template.ParseFiles("templates/referee_display.html")
 	if err != nil {
@@ -402,6 +445,18 @@ func RefereeDisplayHandler(w http.ResponseWriter, r *http.Request) {
 
 	match := mainArena.currentMatch
 	matchType := match.CapitalizedType()
+	redScore := mainArena.redRealtimeScore.WithReadLock()
+	blueScore := mainArena.blueRealtimeScore.WithReadLock()
+	foulsCommitted := redScore.FoulsCommitted && blueScore.FoulsCommitted
+
+	// Before the match is committed, fouls live in refereeCoordinator's pending state rather than on
+	// the RealtimeScore itself, so a referee who adds a foul and gets reloaded sees it right away
+	// instead of an empty list until commitMatch runs.
+	redFouls, blueFouls := redScore.Fouls, blueScore.Fouls
+	if !foulsCommitted {
+		redFouls, blueFouls = refereeCoordinator.PendingFouls()
+	}
+
 	data := struct {
 		*EventSettings
 		MatchType        string
@@ -417,8 +472,7 @@ func RefereeDisplayHandler(w http.ResponseWriter, r *http.Request) {
 		Rules            []string
 		EntryEnabled     bool
 	}{eventSettings, matchType, match.DisplayName, match.Red1, match.Red2, match.Red3, match.Blue1, match.Blue2,
-		match.Blue3, mainArena.redRealtimeScore.Fouls, mainArena.blueRealtimeScore.Fouls, rules,
-		!(mainArena.redRealtimeScore.FoulsCommitted && mainArena.blueRealtimeScore.FoulsCommitted)}
+		match.Blue3, redFouls, blueFouls, rules, !foulsCommitted}
 	err = template.ExecuteTemplate(w, "referee_display.html", data)
 	if err != nil {
 		handleWebErr(w, err)
@@ -428,6 +482,16 @@ func RefereeDisplayHandler(w http.ResponseWriter, r *http.Request) {
 
 // The websocket endpoint for the refereee interface client to send control commands and receive status updates.
 func RefereeDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
+	authToken, ok := authorizeRequest(w, r, RoleHeadRef, RoleRedRef, RoleBlueRef)
+	if !ok {
+		return
+	}
+	seat, ok := refereeSeatForRole(authToken.Role)
+	if !ok {
+		handleWebErr(w, fmt.Errorf("Role '%s' is not a referee seat.", authToken.Role))
+		return
+	}
+
 	websocket, err := NewWebsocket(w, r)
 	if err != nil {
 		handleWebErr(w, err)
@@ -435,8 +499,17 @@ func RefereeDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer websocket.Close()
 
+	refereeCoordinator.Connect(seat)
+	defer refereeCoordinator.Disconnect(seat)
+
 	matchLoadTeamsListener := mainArena.matchLoadTeamsNotifier.Listen()
 	defer close(matchLoadTeamsListener)
+	presenceListener := refereeCoordinator.presenceNotifier.Listen()
+	defer close(presenceListener)
+	pendingListener := refereeCoordinator.pendingNotifier.Listen()
+	defer close(pendingListener)
+
+	seq := new(sequencer)
 
 	// Spin off a goroutine to listen for notifications and pass them on through the websocket.
 	go func() {
@@ -450,8 +523,25 @@ func RefereeDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 				}
 				messageType = "reload"
 				message = nil
+			case presence, ok := <-presenceListener:
+				if !ok {
+					return
+				}
+				messageType = "refereePresence"
+				message = presence
+			case pendingFouls, ok := <-pendingListener:
+				if !ok {
+					return
+				}
+				// Only the head referee sees the consolidated pending list from every seat; alliance
+				// referees see their own fouls reflected back through the regular "reload" flow.
+				if seat != RefereeSeatHead {
+					continue
+				}
+				messageType = "pendingFouls"
+				message = pendingFouls
 			}
-			err = websocket.Write(messageType, message)
+			err = seq.writeEnvelope(websocket, messageType, message)
 			if err != nil {
 				// The client has probably closed the connection; nothing to do here.
 				return
@@ -467,10 +557,11 @@ func RefereeDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 				// Client has closed the connection; nothing to do here.
 				return
 			}
-			log.Printf("Websocket error: %s", err)
+			writeCloseAndReturn(websocket, err)
 			return
 		}
 
+		var commandErr error
 		switch messageType {
 		case "addFoul":
 			args := struct {
@@ -479,20 +570,26 @@ func RefereeDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 				Rule        string
 				IsTechnical bool
 			}{}
-			err = mapstructure.Decode(data, &args)
-			if err != nil {
-				websocket.WriteError(err.Error())
-				continue
+			if err = mapstructure.Decode(data, &args); err != nil {
+				writeCloseAndReturn(websocket, newProtocolError(err.Error()))
+				return
 			}
 
-			// Add the foul to the correct alliance's list.
-			foul := Foul{TeamId: args.TeamId, Rule: args.Rule, IsTechnical: args.IsTechnical,
-				TimeInMatchSec: mainArena.MatchTimeSec()}
-			if args.Alliance == "red" {
-				mainArena.redRealtimeScore.Fouls = append(mainArena.redRealtimeScore.Fouls, foul)
-			} else {
-				mainArena.blueRealtimeScore.Fouls = append(mainArena.blueRealtimeScore.Fouls, foul)
+			ownAlliance, isAllianceRef := allianceForSeat(seat)
+			if !isAllianceRef {
+				commandErr = newUserError("The head referee cannot add fouls directly.")
+				break
 			}
+			if args.Alliance != ownAlliance {
+				commandErr = newUserError("The %s referee may only add fouls for the %s alliance.",
+					seat, ownAlliance)
+				break
+			}
+
+			foul := Foul{TeamId: args.TeamId, Rule: args.Rule, IsTechnical: args.IsTechnical,
+				TimeInMatchSec: mainArena.MatchTimeSec(), AssignedByRefereeId: authToken.Id}
+			refereeCoordinator.AddPendingFoul(seat, foul)
+			auditFoulAction(authToken, "addFoul", args.Alliance, args.TeamId, args.Rule)
 		case "deleteFoul":
 			args := struct {
 				Alliance       string
@@ -501,40 +598,79 @@ func RefereeDisplayWebsocketHandler(w http.ResponseWriter, r *http.Request) {
 				TimeInMatchSec float64
 				IsTechnical    bool
 			}{}
-			err = mapstructure.Decode(data, &args)
-			if err != nil {
-				websocket.WriteError(err.Error())
-				continue
+			if err = mapstructure.Decode(data, &args); err != nil {
+				writeCloseAndReturn(websocket, newProtocolError(err.Error()))
+				return
 			}
 
-			// Remove the foul from the correct alliance's list.
-			deleteFoul := Foul{TeamId: args.TeamId, Rule: args.Rule, IsTechnical: args.IsTechnical,
-				TimeInMatchSec: args.TimeInMatchSec}
-			var fouls *[]Foul
-			if args.Alliance == "red" {
-				fouls = &mainArena.redRealtimeScore.Fouls
-			} else {
-				fouls = &mainArena.blueRealtimeScore.Fouls
+			ownAlliance, isAllianceRef := allianceForSeat(seat)
+			if !isAllianceRef || args.Alliance != ownAlliance {
+				commandErr = newUserError("The %s referee may only delete fouls for the %s alliance.",
+					seat, ownAlliance)
+				break
 			}
-			for i, foul := range *fouls {
-				if foul == deleteFoul {
-					*fouls = append((*fouls)[:i], (*fouls)[i+1:]...)
-					break
-				}
+
+			deleteFoul := Foul{TeamId: args.TeamId, Rule: args.Rule, IsTechnical: args.IsTechnical,
+				TimeInMatchSec: args.TimeInMatchSec, AssignedByRefereeId: authToken.Id}
+			refereeCoordinator.DeletePendingFoul(seat, deleteFoul)
+			auditFoulAction(authToken, "deleteFoul", args.Alliance, args.TeamId, args.Rule)
+		case "ackCommit":
+			if _, isAllianceRef := allianceForSeat(seat); !isAllianceRef {
+				commandErr = newUserError("Only the alliance referees acknowledge a commit.")
+				break
 			}
+			refereeCoordinator.Acknowledge(seat)
+			auditFoulAction(authToken, "ackCommit", "", 0, "")
+		case "undo":
+			refereeCoordinator.Undo(seat)
 		case "commitMatch":
-			mainArena.redRealtimeScore.FoulsCommitted = true
-			mainArena.blueRealtimeScore.FoulsCommitted = true
+			if seat != RefereeSeatHead {
+				commandErr = newUserError("Only the head referee may commit the match.")
+				break
+			}
+			args := struct {
+				Override bool
+			}{}
+			mapstructure.Decode(data, &args)
+
+			ready, readyErr := refereeCoordinator.ReadyToCommit(args.Override)
+			if readyErr != nil {
+				commandErr = newUserError(readyErr.Error())
+				break
+			}
+			if !ready {
+				commandErr = newUserError("Waiting for both alliance referees to acknowledge the commit.")
+				break
+			}
+
+			redFouls, blueFouls := refereeCoordinator.FinalizeAndReset()
+			mainArena.redRealtimeScore.WithWriteLock(func(score *RealtimeScore) {
+				score.Fouls = append(score.Fouls, redFouls...)
+				score.FoulsCommitted = true
+			})
+			mainArena.blueRealtimeScore.WithWriteLock(func(score *RealtimeScore) {
+				score.Fouls = append(score.Fouls, blueFouls...)
+				score.FoulsCommitted = true
+			})
+			auditFoulAction(authToken, "commitMatch", "", 0, "")
 		default:
-			websocket.WriteError(fmt.Sprintf("Invalid message type '%s'.", messageType))
+			writeCloseAndReturn(websocket, newProtocolError("Invalid message type '%s'.", messageType))
+			return
+		}
+
+		if commandErr != nil {
+			if err = seq.writeEnvelope(websocket, "error", commandErr.Error()); err != nil {
+				writeCloseAndReturn(websocket, err)
+				return
+			}
 			continue
 		}
 
 		// Force a reload of the client to render the updated foul list.
-		err = websocket.Write("reload", nil)
+		err = seq.writeEnvelope(websocket, "reload", nil)
 		if err != nil {
-			log.Printf("Websocket error: %s", err)
+			writeCloseAndReturn(websocket, err)
 			return
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,15 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+//
+// A single rule violation assessed against a team during a match.
+
+package main
+
+// Foul represents a single foul called against a team at a point in the match.
+type Foul struct {
+	TeamId              int
+	Rule                string
+	IsTechnical         bool
+	TimeInMatchSec      float64
+	AssignedByRefereeId string
+}
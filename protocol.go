@@ -0,0 +1,114 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+//
+// Error classification and versioned message envelopes for display websocket handlers, so that
+// clients can tell a bad command apart from a server crash or a match reset.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Close codes sent in the "close" envelope that precedes tearing down a display websocket. These
+// mirror the RFC 6455 status codes of the same number.
+const (
+	closeCodeNormal   = 1000
+	closeCodeProtocol = 1002
+	closeCodeInternal = 1011
+)
+
+// ProtocolError means the client sent a message the server doesn't understand, such as an unknown
+// message type or arguments that don't decode. It's the client's fault and closes with
+// closeCodeProtocol.
+type ProtocolError struct {
+	message string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.message
+}
+
+func newProtocolError(format string, a ...interface{}) error {
+	return &ProtocolError{fmt.Sprintf(format, a...)}
+}
+
+// UserError means the command was well-formed but can't be applied given the current match state
+// (e.g. committing a match that's already committed). It's user-visible but not a bug. A handler may
+// either close the connection over it via writeCloseAndReturn, which maps it to closeCodeNormal
+// through closeCodeForError, or, for a single rejected command that shouldn't drop the rest of the
+// session, send it inline as an "error" message and keep reading (as the referee handler does).
+type UserError struct {
+	message string
+}
+
+func (e *UserError) Error() string {
+	return e.message
+}
+
+func newUserError(format string, a ...interface{}) error {
+	return &UserError{fmt.Sprintf(format, a...)}
+}
+
+// closeCodeForError maps a classified error to the close code that should accompany it; anything
+// that isn't a ProtocolError or UserError is treated as an internal error.
+func closeCodeForError(err error) int {
+	switch err.(type) {
+	case *ProtocolError:
+		return closeCodeProtocol
+	case *UserError:
+		return closeCodeNormal
+	default:
+		return closeCodeInternal
+	}
+}
+
+// closeMessage is the payload of the "close" envelope sent to the client just before the connection
+// is torn down.
+type closeMessage struct {
+	Code   int
+	Reason string
+}
+
+// writeCloseAndReturn classifies err, sends a "close" envelope carrying the matching close code and
+// message, logs internal errors (but not client-caused ones), and returns err so the caller's
+// handler can return immediately afterwards.
+func writeCloseAndReturn(websocket *Websocket, err error) error {
+	code := closeCodeForError(err)
+	if writeErr := websocket.Write("close", closeMessage{Code: code, Reason: err.Error()}); writeErr != nil {
+		log.Printf("Failed to write close envelope: %s", writeErr)
+	}
+	if code == closeCodeInternal {
+		log.Printf("Websocket error: %s", err)
+	}
+	return err
+}
+
+// envelope wraps every outgoing display message except the final "close" message (which
+// writeCloseAndReturn sends bare) with a server-assigned sequence number so the client can detect a
+// missed event (a gap in Seq) and request a resync rather than silently desyncing from the arena's
+// state.
+type envelope struct {
+	Seq     int
+	Payload interface{}
+}
+
+// sequencer assigns monotonically increasing sequence numbers to outgoing messages on a single
+// websocket connection. Each display handler owns one instance for the lifetime of its connection
+// and shares it between its notification goroutine and its command loop, so it's safe for
+// concurrent use.
+type sequencer struct {
+	mutex sync.Mutex
+	seq   int
+}
+
+// writeEnvelope assigns the next sequence number and writes messageType/payload wrapped in an
+// envelope. The lock is held across the write so messages go out in sequence-number order.
+func (s *sequencer) writeEnvelope(websocket *Websocket, messageType string, payload interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seq++
+	return websocket.Write(messageType, envelope{Seq: s.seq, Payload: payload})
+}
@@ -0,0 +1,39 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCloseCodeForError(t *testing.T) {
+	cases := []struct {
+		err      error
+		wantCode int
+	}{
+		{newProtocolError("invalid message type '%s'", "foo"), closeCodeProtocol},
+		{newUserError("match already committed"), closeCodeNormal},
+		{errors.New("boom"), closeCodeInternal},
+	}
+	for _, c := range cases {
+		if code := closeCodeForError(c.err); code != c.wantCode {
+			t.Errorf("closeCodeForError(%v) = %d, want %d", c.err, code, c.wantCode)
+		}
+	}
+}
+
+func TestNewProtocolErrorFormatsMessage(t *testing.T) {
+	err := newProtocolError("invalid message type '%s'", "foo")
+	if err.Error() != "invalid message type 'foo'" {
+		t.Errorf("got message %q", err.Error())
+	}
+}
+
+func TestNewUserErrorFormatsMessage(t *testing.T) {
+	err := newUserError("the %s referee may only add fouls for the %s alliance", "red", "red")
+	if err.Error() != "the red referee may only add fouls for the red alliance" {
+		t.Errorf("got message %q", err.Error())
+	}
+}
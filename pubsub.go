@@ -0,0 +1,128 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+//
+// Server-Sent Events streaming for read-only displays, so field displays can subscribe to match
+// events without the connection-management overhead of a websocket. The SSE handler listens directly
+// to the same arena notifiers that the websocket display handlers listen to; this file does not wrap
+// those notifiers, and the websocket handlers use nothing from it.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// EventStream renders a stream of named events to an http.ResponseWriter using the
+// text/event-stream format, for handlers that only ever push to read-only clients.
+type EventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// NewEventStream prepares w for Server-Sent Events and returns a stream that can push events to it,
+// or an error if the underlying ResponseWriter doesn't support flushing.
+func NewEventStream(w http.ResponseWriter, r *http.Request) (*EventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming not supported by this connection")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+	return &EventStream{w: w, flusher: flusher}, nil
+}
+
+// Write sends a single SSE event with the given name and JSON-serializable payload, then flushes it
+// to the client immediately.
+func (stream *EventStream) Write(eventName string, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(stream.w, "event: %s\ndata: %s\n\n", eventName, payloadBytes); err != nil {
+		return err
+	}
+	stream.flusher.Flush()
+	return nil
+}
+
+// servePitAndAnnouncerEvents streams matchTime, matchTiming, reload, and scorePosted events to a
+// read-only client, backed by the same notifiers the websocket handlers subscribe to. It returns when
+// the request context is canceled (the client disconnected) or a write fails.
+func servePitAndAnnouncerEvents(w http.ResponseWriter, r *http.Request) {
+	stream, err := NewEventStream(w, r)
+	if err != nil {
+		handleWebErr(w, err)
+		return
+	}
+
+	matchLoadTeamsListener := mainArena.matchLoadTeamsNotifier.Listen()
+	defer close(matchLoadTeamsListener)
+	matchTimeListener := mainArena.matchTimeNotifier.Listen()
+	defer close(matchTimeListener)
+	scorePostedListener := mainArena.scorePostedNotifier.Listen()
+	defer close(scorePostedListener)
+
+	if err = stream.Write("matchTiming", mainArena.matchTiming); err != nil {
+		return
+	}
+	data := MatchTimeMessage{mainArena.MatchState, int(mainArena.lastMatchTimeSec)}
+	if err = stream.Write("matchTime", data); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case _, ok := <-matchLoadTeamsListener:
+			if !ok {
+				return
+			}
+			if err = stream.Write("reload", nil); err != nil {
+				return
+			}
+		case matchTimeSec, ok := <-matchTimeListener:
+			if !ok {
+				return
+			}
+			data := MatchTimeMessage{mainArena.MatchState, matchTimeSec.(int)}
+			if err = stream.Write("matchTime", data); err != nil {
+				return
+			}
+		case _, ok := <-scorePostedListener:
+			if !ok {
+				return
+			}
+			if err = stream.Write("scorePosted", nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// PitDisplaySSEHandler streams read-only match events to the pit display over SSE instead of a
+// websocket, since the pit display never sends commands back to the server.
+func PitDisplaySSEHandler(w http.ResponseWriter, r *http.Request) {
+	servePitAndAnnouncerEvents(w, r)
+}
+
+// AnnouncerDisplaySSEHandler streams read-only match events to the announcer display over SSE for
+// press-box browsers that only need to observe, not control, the match.
+func AnnouncerDisplaySSEHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeRequest(w, r, RoleAnnouncer, RoleReadonly); !ok {
+		return
+	}
+	servePitAndAnnouncerEvents(w, r)
+}
+
+// RegisterPubsubRoutes wires this file's handlers into router. Called from the same startup path
+// that registers the rest of the display routes.
+func RegisterPubsubRoutes(router *mux.Router) {
+	router.HandleFunc("/displays/pit/sse", PitDisplaySSEHandler)
+	router.HandleFunc("/displays/announcer/sse", AnnouncerDisplaySSEHandler)
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+//
+// Live, in-progress scoring state for a single alliance during a match, guarded by a mutex so that
+// concurrent websocket handlers (scoring, referee) can mutate it safely.
+
+package main
+
+import (
+	"sync"
+)
+
+// RealtimeScore holds the score for one alliance as it's being built up over the course of a match.
+// All access from the websocket handlers must go through WithWriteLock/WithReadLock rather than
+// touching the embedded fields directly, since a referee goroutine adding a foul and a scorekeeper
+// goroutine committing a cycle can otherwise race on the same struct.
+type RealtimeScore struct {
+	mutex sync.RWMutex
+
+	CurrentScore       Score
+	CurrentCycle       Cycle
+	undoCycles         []Cycle
+	undoAutoScores     []Score
+	AutoCommitted      bool
+	AutoPreloadedBalls int
+	TeleopCommitted    bool
+	Fouls              []Foul
+	FoulsCommitted     bool
+}
+
+// RealtimeScoreSnapshot is a point-in-time copy of a RealtimeScore's exported fields, with no mutex,
+// so it's safe to read, marshal to JSON, or hand off to another goroutine without holding any lock.
+// (Returning a RealtimeScore by value instead would copy its embedded sync.RWMutex, which go vet
+// correctly rejects regardless of the mutex's runtime state.)
+type RealtimeScoreSnapshot struct {
+	CurrentScore       Score
+	CurrentCycle       Cycle
+	AutoCommitted      bool
+	AutoPreloadedBalls int
+	TeleopCommitted    bool
+	Fouls              []Foul
+	FoulsCommitted     bool
+}
+
+// NewRealtimeScore creates a fresh, zeroed-out RealtimeScore for the start of a match.
+func NewRealtimeScore() *RealtimeScore {
+	return new(RealtimeScore)
+}
+
+// allianceRealtimeScore returns the live RealtimeScore for the given alliance ("red" or "blue").
+func allianceRealtimeScore(alliance string) *RealtimeScore {
+	if alliance == "red" {
+		return mainArena.redRealtimeScore
+	}
+	return mainArena.blueRealtimeScore
+}
+
+// WithWriteLock takes the write lock, applies mutate to the live score, and returns a snapshot of
+// the result that's safe to hand off to a goroutine for broadcast.
+func (score *RealtimeScore) WithWriteLock(mutate func(*RealtimeScore)) RealtimeScoreSnapshot {
+	score.mutex.Lock()
+	defer score.mutex.Unlock()
+	mutate(score)
+	return score.snapshotLocked()
+}
+
+// WithReadLock takes the read lock and returns a snapshot of the current score, for readers that
+// only observe the score rather than mutate it.
+func (score *RealtimeScore) WithReadLock() RealtimeScoreSnapshot {
+	score.mutex.RLock()
+	defer score.mutex.RUnlock()
+	return score.snapshotLocked()
+}
+
+// snapshotLocked copies the exported fields of the score into a mutex-free snapshot. Callers must
+// hold at least the read lock.
+//
+// CurrentScore is copied field-by-field rather than by simply assigning score.CurrentScore, because
+// Score embeds a Cycles slice: a plain struct copy would still share Cycles' backing array with the
+// live RealtimeScore, so a later score.CurrentScore.Cycles = append(...) under the write lock could
+// grow or overwrite the same backing array a goroutine is concurrently marshaling from an
+// already-returned snapshot.
+func (score *RealtimeScore) snapshotLocked() RealtimeScoreSnapshot {
+	currentScore := score.CurrentScore
+	currentScore.Cycles = append([]Cycle{}, score.CurrentScore.Cycles...)
+	return RealtimeScoreSnapshot{
+		CurrentScore:       currentScore,
+		CurrentCycle:       score.CurrentCycle,
+		AutoCommitted:      score.AutoCommitted,
+		AutoPreloadedBalls: score.AutoPreloadedBalls,
+		TeleopCommitted:    score.TeleopCommitted,
+		Fouls:              append([]Foul{}, score.Fouls...),
+		FoulsCommitted:     score.FoulsCommitted,
+	}
+}
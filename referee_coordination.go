@@ -0,0 +1,247 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+//
+// Coordination between the three referee seats (head, red alliance, blue alliance) so that each
+// alliance referee only affects their own alliance's fouls, the head referee sees a live view of
+// fouls the alliance referees haven't finalized yet, and committing the match requires both alliance
+// referees to acknowledge (or the head referee to explicitly override).
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RefereeSeat identifies which of the three referee tablets a websocket connection is acting as.
+type RefereeSeat string
+
+const (
+	RefereeSeatHead RefereeSeat = "head"
+	RefereeSeatRed  RefereeSeat = "red"
+	RefereeSeatBlue RefereeSeat = "blue"
+)
+
+// commitAckTimeout is how long after the first alliance referee's acknowledgement the other has to
+// acknowledge; once it has passed without both, a commitMatch without an explicit override reports a
+// timeout error.
+const commitAckTimeout = 20 * time.Second
+
+// refereeSeatForRole maps the authenticated role of a referee websocket connection to the seat it's
+// allowed to occupy.
+func refereeSeatForRole(role Role) (RefereeSeat, bool) {
+	switch role {
+	case RoleHeadRef:
+		return RefereeSeatHead, true
+	case RoleRedRef:
+		return RefereeSeatRed, true
+	case RoleBlueRef:
+		return RefereeSeatBlue, true
+	default:
+		return "", false
+	}
+}
+
+// allianceForSeat returns the alliance ("red" or "blue") that an alliance referee seat may mutate
+// fouls for, or false if the seat is the head referee (who doesn't own an alliance).
+func allianceForSeat(seat RefereeSeat) (string, bool) {
+	switch seat {
+	case RefereeSeatRed:
+		return "red", true
+	case RefereeSeatBlue:
+		return "blue", true
+	default:
+		return "", false
+	}
+}
+
+// RefereeCoordinator tracks which referee seats are connected, each alliance referee's fouls that
+// haven't yet been finalized into the committed score, the commit acknowledgement handshake, and a
+// per-seat undo stack so one referee's undo never rolls back another's work.
+type RefereeCoordinator struct {
+	mutex sync.Mutex
+
+	presenceNotifier *Notifier
+	pendingNotifier  *Notifier
+
+	connectedSeats map[RefereeSeat]bool
+	pendingFouls   map[RefereeSeat][]Foul
+	acks           map[RefereeSeat]bool
+	ackDeadline    time.Time
+	undoStacks     map[RefereeSeat][]func()
+}
+
+// NewRefereeCoordinator creates a coordinator with empty state, ready for the start of a match.
+func NewRefereeCoordinator() *RefereeCoordinator {
+	return &RefereeCoordinator{
+		presenceNotifier: new(Notifier),
+		pendingNotifier:  new(Notifier),
+		connectedSeats:   make(map[RefereeSeat]bool),
+		pendingFouls:     make(map[RefereeSeat][]Foul),
+		acks:             make(map[RefereeSeat]bool),
+		undoStacks:       make(map[RefereeSeat][]func()),
+	}
+}
+
+// refereeCoordinator is the single coordinator shared by every referee websocket connection for the
+// current match.
+var refereeCoordinator = NewRefereeCoordinator()
+
+// Connect marks seat as present and broadcasts the updated presence to the other referees. The
+// caller listens to presenceNotifier itself and must call Disconnect when its connection ends.
+func (c *RefereeCoordinator) Connect(seat RefereeSeat) {
+	c.mutex.Lock()
+	c.connectedSeats[seat] = true
+	presence := c.presenceLocked()
+	c.mutex.Unlock()
+	c.presenceNotifier.Notify(presence)
+}
+
+// Disconnect marks seat as no longer present and broadcasts the updated presence.
+func (c *RefereeCoordinator) Disconnect(seat RefereeSeat) {
+	c.mutex.Lock()
+	delete(c.connectedSeats, seat)
+	presence := c.presenceLocked()
+	c.mutex.Unlock()
+	c.presenceNotifier.Notify(presence)
+}
+
+// Presence returns which of the three seats currently have a connected client.
+func (c *RefereeCoordinator) Presence() map[RefereeSeat]bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.presenceLocked()
+}
+
+// presenceLocked builds the presence map; callers must hold the mutex.
+func (c *RefereeCoordinator) presenceLocked() map[RefereeSeat]bool {
+	presence := make(map[RefereeSeat]bool)
+	for _, seat := range []RefereeSeat{RefereeSeatHead, RefereeSeatRed, RefereeSeatBlue} {
+		presence[seat] = c.connectedSeats[seat]
+	}
+	return presence
+}
+
+// AddPendingFoul records a foul an alliance referee has called but not yet finalized, pushes an undo
+// action that removes it again, and broadcasts the updated pending list to the head referee.
+func (c *RefereeCoordinator) AddPendingFoul(seat RefereeSeat, foul Foul) {
+	c.mutex.Lock()
+	c.pendingFouls[seat] = append(c.pendingFouls[seat], foul)
+	c.undoStacks[seat] = append(c.undoStacks[seat], func() {
+		fouls := c.pendingFouls[seat]
+		for i := len(fouls) - 1; i >= 0; i-- {
+			if fouls[i] == foul {
+				c.pendingFouls[seat] = append(fouls[:i], fouls[i+1:]...)
+				return
+			}
+		}
+	})
+	pending := c.pendingFoulsSnapshotLocked()
+	c.mutex.Unlock()
+	c.pendingNotifier.Notify(pending)
+}
+
+// DeletePendingFoul removes a not-yet-finalized foul that the given seat called, matching on the
+// content fields the client actually supplies rather than full struct equality -- foul.
+// AssignedByRefereeId is re-derived from the caller's current token, which may no longer match the
+// original foul's attribution if the referee's tablet reconnected with a new token in between.
+func (c *RefereeCoordinator) DeletePendingFoul(seat RefereeSeat, foul Foul) {
+	c.mutex.Lock()
+	fouls := c.pendingFouls[seat]
+	for i, pending := range fouls {
+		if foulContentEquals(pending, foul) {
+			c.pendingFouls[seat] = append(fouls[:i], fouls[i+1:]...)
+			break
+		}
+	}
+	pendingFouls := c.pendingFoulsSnapshotLocked()
+	c.mutex.Unlock()
+	c.pendingNotifier.Notify(pendingFouls)
+}
+
+// Undo pops and runs the given seat's own most recent action, leaving every other seat's work alone.
+func (c *RefereeCoordinator) Undo(seat RefereeSeat) {
+	c.mutex.Lock()
+	stack := c.undoStacks[seat]
+	if len(stack) == 0 {
+		c.mutex.Unlock()
+		return
+	}
+	action := stack[len(stack)-1]
+	c.undoStacks[seat] = stack[:len(stack)-1]
+	action()
+	pendingFouls := c.pendingFoulsSnapshotLocked()
+	c.mutex.Unlock()
+	c.pendingNotifier.Notify(pendingFouls)
+}
+
+// PendingFouls returns a deep copy of the not-yet-finalized fouls called by each alliance referee,
+// for rendering on the referee display before the match is committed.
+func (c *RefereeCoordinator) PendingFouls() (redFouls, blueFouls []Foul) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]Foul{}, c.pendingFouls[RefereeSeatRed]...), append([]Foul{}, c.pendingFouls[RefereeSeatBlue]...)
+}
+
+// pendingFoulsSnapshotLocked deep-copies the pending-fouls map so it's safe to hand to a notifier
+// after the mutex is released -- notifying the live map by reference would let a listener goroutine
+// marshal it concurrently with another seat's write to the same map, which is a hard runtime fatal
+// error in Go, not just a data race. Callers must hold the mutex.
+func (c *RefereeCoordinator) pendingFoulsSnapshotLocked() map[RefereeSeat][]Foul {
+	snapshot := make(map[RefereeSeat][]Foul, len(c.pendingFouls))
+	for seat, fouls := range c.pendingFouls {
+		snapshot[seat] = append([]Foul{}, fouls...)
+	}
+	return snapshot
+}
+
+// foulContentEquals compares the fields a client supplies when identifying a foul to add or delete,
+// ignoring AssignedByRefereeId.
+func foulContentEquals(a, b Foul) bool {
+	return a.TeamId == b.TeamId && a.Rule == b.Rule && a.IsTechnical == b.IsTechnical &&
+		a.TimeInMatchSec == b.TimeInMatchSec
+}
+
+// Acknowledge records that seat has acknowledged the pending commit. It starts the acknowledgement
+// deadline on the first acknowledgement since the last reset; later acknowledgements leave it as is.
+func (c *RefereeCoordinator) Acknowledge(seat RefereeSeat) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.acks) == 0 {
+		c.ackDeadline = time.Now().Add(commitAckTimeout)
+	}
+	c.acks[seat] = true
+}
+
+// ReadyToCommit reports whether both alliance referees have acknowledged, or override is true (the
+// head referee's explicit override of a stalled or unresponsive alliance referee).
+func (c *RefereeCoordinator) ReadyToCommit(override bool) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if override {
+		return true, nil
+	}
+	if c.acks[RefereeSeatRed] && c.acks[RefereeSeatBlue] {
+		return true, nil
+	}
+	if !c.ackDeadline.IsZero() && time.Now().After(c.ackDeadline) {
+		return false, fmt.Errorf("acknowledgement timed out waiting for both alliance referees; " +
+			"use override to commit anyway")
+	}
+	return false, nil
+}
+
+// FinalizeAndReset moves every pending foul into the alliance's committed fouls, clears the pending
+// and acknowledgement state for the next match, and returns the finalized red and blue foul lists.
+func (c *RefereeCoordinator) FinalizeAndReset() (redFouls, blueFouls []Foul) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	redFouls = c.pendingFouls[RefereeSeatRed]
+	blueFouls = c.pendingFouls[RefereeSeatBlue]
+	c.pendingFouls = make(map[RefereeSeat][]Foul)
+	c.acks = make(map[RefereeSeat]bool)
+	c.ackDeadline = time.Time{}
+	c.undoStacks = make(map[RefereeSeat][]func())
+	return redFouls, blueFouls
+}
@@ -0,0 +1,119 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+
+package main
+
+import "testing"
+
+func TestRefereeSeatForRole(t *testing.T) {
+	cases := []struct {
+		role     Role
+		wantSeat RefereeSeat
+		wantOk   bool
+	}{
+		{RoleHeadRef, RefereeSeatHead, true},
+		{RoleRedRef, RefereeSeatRed, true},
+		{RoleBlueRef, RefereeSeatBlue, true},
+		{RoleReadonly, "", false},
+	}
+	for _, c := range cases {
+		seat, ok := refereeSeatForRole(c.role)
+		if seat != c.wantSeat || ok != c.wantOk {
+			t.Errorf("refereeSeatForRole(%s) = (%s, %v), want (%s, %v)", c.role, seat, ok, c.wantSeat, c.wantOk)
+		}
+	}
+}
+
+func TestAllianceForSeat(t *testing.T) {
+	if alliance, ok := allianceForSeat(RefereeSeatRed); alliance != "red" || !ok {
+		t.Errorf("expected (red, true), got (%s, %v)", alliance, ok)
+	}
+	if alliance, ok := allianceForSeat(RefereeSeatBlue); alliance != "blue" || !ok {
+		t.Errorf("expected (blue, true), got (%s, %v)", alliance, ok)
+	}
+	if _, ok := allianceForSeat(RefereeSeatHead); ok {
+		t.Error("expected the head referee seat to not own an alliance")
+	}
+}
+
+func TestAddAndDeletePendingFoul(t *testing.T) {
+	coordinator := NewRefereeCoordinator()
+	foul := Foul{TeamId: 254, Rule: "G1", TimeInMatchSec: 12.5}
+	coordinator.AddPendingFoul(RefereeSeatRed, foul)
+
+	redFouls, blueFouls := coordinator.PendingFouls()
+	if len(redFouls) != 1 || len(blueFouls) != 0 {
+		t.Fatalf("expected one pending red foul, got red=%v blue=%v", redFouls, blueFouls)
+	}
+
+	// A delete built from a newer token -- and thus a different AssignedByRefereeId -- should still
+	// match on content.
+	deleteFoul := foul
+	deleteFoul.AssignedByRefereeId = "a-different-token-id"
+	coordinator.DeletePendingFoul(RefereeSeatRed, deleteFoul)
+
+	redFouls, _ = coordinator.PendingFouls()
+	if len(redFouls) != 0 {
+		t.Fatalf("expected the pending foul to be deleted despite the differing attribution, got %v", redFouls)
+	}
+}
+
+func TestUndoOnlyRollsBackOwnSeat(t *testing.T) {
+	coordinator := NewRefereeCoordinator()
+	coordinator.AddPendingFoul(RefereeSeatRed, Foul{TeamId: 254})
+	coordinator.AddPendingFoul(RefereeSeatBlue, Foul{TeamId: 1114})
+
+	coordinator.Undo(RefereeSeatRed)
+
+	redFouls, blueFouls := coordinator.PendingFouls()
+	if len(redFouls) != 0 {
+		t.Errorf("expected red's foul to be undone, got %v", redFouls)
+	}
+	if len(blueFouls) != 1 {
+		t.Errorf("expected blue's foul to be untouched, got %v", blueFouls)
+	}
+}
+
+func TestReadyToCommit(t *testing.T) {
+	coordinator := NewRefereeCoordinator()
+	if ready, err := coordinator.ReadyToCommit(false); ready || err != nil {
+		t.Fatalf("expected not ready with no acknowledgements, got ready=%v err=%v", ready, err)
+	}
+	if ready, err := coordinator.ReadyToCommit(true); !ready || err != nil {
+		t.Fatalf("expected override to always be ready, got ready=%v err=%v", ready, err)
+	}
+
+	coordinator.Acknowledge(RefereeSeatRed)
+	if ready, _ := coordinator.ReadyToCommit(false); ready {
+		t.Error("expected not ready with only one alliance referee acknowledged")
+	}
+
+	coordinator.Acknowledge(RefereeSeatBlue)
+	if ready, err := coordinator.ReadyToCommit(false); !ready || err != nil {
+		t.Errorf("expected ready once both alliance referees acknowledge, got ready=%v err=%v", ready, err)
+	}
+}
+
+func TestFinalizeAndResetClearsState(t *testing.T) {
+	coordinator := NewRefereeCoordinator()
+	coordinator.AddPendingFoul(RefereeSeatRed, Foul{TeamId: 254})
+	coordinator.AddPendingFoul(RefereeSeatBlue, Foul{TeamId: 1114})
+	coordinator.Acknowledge(RefereeSeatRed)
+	coordinator.Acknowledge(RefereeSeatBlue)
+
+	redFouls, blueFouls := coordinator.FinalizeAndReset()
+	if len(redFouls) != 1 || redFouls[0].TeamId != 254 {
+		t.Errorf("expected red's foul to be finalized, got %v", redFouls)
+	}
+	if len(blueFouls) != 1 || blueFouls[0].TeamId != 1114 {
+		t.Errorf("expected blue's foul to be finalized, got %v", blueFouls)
+	}
+
+	remainingRed, remainingBlue := coordinator.PendingFouls()
+	if len(remainingRed) != 0 || len(remainingBlue) != 0 {
+		t.Error("expected pending fouls to be cleared after finalizing")
+	}
+	if ready, _ := coordinator.ReadyToCommit(false); ready {
+		t.Error("expected acknowledgements to be cleared after finalizing")
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+//
+// Pushes posted match results to configured external targets (TBA, an FMS mirror, or a generic
+// webhook), retrying with backoff and persisting deliveries to an outbox. A delivery whose retries run
+// out while its target is offline is not tried again until the next process start, when Start
+// resumes every persisted delivery that hasn't been delivered.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ResultsPushFormat selects how the match result is shaped before it's sent to a target.
+type ResultsPushFormat string
+
+const (
+	ResultsPushFormatTba     ResultsPushFormat = "tba"
+	ResultsPushFormatFms     ResultsPushFormat = "fms"
+	ResultsPushFormatGeneric ResultsPushFormat = "generic"
+)
+
+// ResultsPushTarget is one external endpoint that posted match results should be delivered to.
+type ResultsPushTarget struct {
+	Name               string
+	Url                string
+	Token              string
+	Format             ResultsPushFormat
+	InsecureSkipVerify bool
+}
+
+// resultsPushDelivery is a single queued attempt to deliver a match result to a target. It's
+// persisted via db.CreateResultsPushDelivery/db.UpdateResultsPushDelivery so a target that's offline
+// when the match is posted still gets the result after a restart, rather than the delivery being
+// silently dropped.
+type resultsPushDelivery struct {
+	Id        int
+	Target    ResultsPushTarget
+	MatchId   int
+	Body      []byte
+	Attempts  int
+	Delivered bool
+	LastError string
+}
+
+// resultsPushPermanentError marks a delivery failure that retrying won't fix (the target rejected
+// the request outright with a 4xx), so deliverWithRetry gives up immediately instead of retrying it
+// identically to a transient 5xx or transport error.
+type resultsPushPermanentError struct {
+	message string
+}
+
+func (e *resultsPushPermanentError) Error() string {
+	return e.message
+}
+
+// ResultsPushOutbox queues deliveries, persists them, and retries each with exponential backoff for
+// at most 8 attempts, stopping at once on a 4xx. A delivery that still hasn't succeeded stays
+// persisted as undelivered and is only retried again when Start runs on the next process start.
+type ResultsPushOutbox struct {
+	mutex          sync.Mutex
+	nextId         int
+	deliveries     []*resultsPushDelivery
+	statusNotifier *Notifier
+	client         *resultsPushClient
+}
+
+// NewResultsPushOutbox creates an outbox backed by statusNotifier, which is fired every time a
+// delivery attempt finishes so an admin websocket can stream live status to operators. It does no
+// I/O and starts no goroutines itself -- call Start once db and mainArena are up to actually resume
+// persisted deliveries and begin listening for newly-posted match results. This split exists because
+// resultsPushOutbox below is a package-level var: a package-level initializer runs before main() has
+// had a chance to open db, so doing the db reload here would nil-deref and crash the server on every
+// process start rather than just failing to push results.
+func NewResultsPushOutbox(statusNotifier *Notifier) *ResultsPushOutbox {
+	return &ResultsPushOutbox{statusNotifier: statusNotifier, client: newResultsPushClient()}
+}
+
+// Start resumes any deliveries that were persisted but not yet delivered before the last restart and
+// begins listening for newly-posted match results. The caller must invoke this once, from the same
+// startup path that opens db and wires up mainArena, before any match can be posted -- not from
+// NewResultsPushOutbox or a package-level var initializer, both of which run too early for db to be
+// ready.
+func (outbox *ResultsPushOutbox) Start() {
+	pending, err := db.GetNondeliveredResultsPushDeliveries()
+	if err != nil {
+		log.Printf("Failed to load persisted results push deliveries: %s", err)
+	}
+	for _, delivery := range pending {
+		if delivery.Id > outbox.nextId {
+			outbox.nextId = delivery.Id
+		}
+		outbox.deliveries = append(outbox.deliveries, delivery)
+		go outbox.deliverWithRetry(delivery)
+	}
+
+	go outbox.listenForScorePosted()
+}
+
+// listenForScorePosted mirrors AnnouncerDisplayWebsocketHandler's notifier pattern: every time a
+// match result is posted, enqueue it for delivery to the configured external targets. Without this,
+// nothing ever triggered a delivery and the feature never fired. It is started by Start and calls
+// outbox.enqueueScorePosted on its own receiver.
+func (outbox *ResultsPushOutbox) listenForScorePosted() {
+	scorePostedListener := mainArena.scorePostedNotifier.Listen()
+	defer close(scorePostedListener)
+	for range scorePostedListener {
+		outbox.enqueueScorePosted(mainArena.savedMatchResult)
+	}
+}
+
+// enqueueScorePosted gathers the current score summaries and fouls for matchResult and queues them
+// for delivery to every configured external target.
+func (outbox *ResultsPushOutbox) enqueueScorePosted(matchResult *MatchResult) {
+	outbox.Enqueue(matchResult, matchResult.RedScoreSummary(), matchResult.BlueScoreSummary(),
+		mainArena.redRealtimeScore.WithReadLock().Fouls, mainArena.blueRealtimeScore.WithReadLock().Fouls)
+}
+
+// Enqueue persists and queues a match result for delivery to every configured target and
+// immediately attempts delivery in the background.
+func (outbox *ResultsPushOutbox) Enqueue(matchResult *MatchResult, redSummary, blueSummary *ScoreSummary,
+	redFouls, blueFouls []Foul) {
+	for _, target := range eventSettings.ResultsPushTargets {
+		body, err := buildResultsPushBody(target.Format, matchResult, redSummary, blueSummary, redFouls, blueFouls)
+		if err != nil {
+			log.Printf("Failed to build results push body for target %s: %s", target.Name, err)
+			continue
+		}
+		outbox.mutex.Lock()
+		outbox.nextId++
+		delivery := &resultsPushDelivery{
+			Id: outbox.nextId, Target: target, MatchId: matchResult.MatchId, Body: body,
+		}
+		outbox.deliveries = append(outbox.deliveries, delivery)
+		outbox.mutex.Unlock()
+
+		if err := db.CreateResultsPushDelivery(delivery); err != nil {
+			log.Printf("Failed to persist results push delivery for target %s: %s", target.Name, err)
+		}
+		go outbox.deliverWithRetry(delivery)
+	}
+}
+
+// deliverWithRetry attempts delivery with exponential backoff on 5xx responses or transport errors,
+// giving up immediately on a 4xx (which retrying identically won't fix), persisting the delivery's
+// state after every attempt, and notifying statusNotifier's listeners with a locked snapshot rather
+// than the live pointer so a concurrent reader never observes a half-updated delivery.
+func (outbox *ResultsPushOutbox) deliverWithRetry(delivery *resultsPushDelivery) {
+	backoff := time.Second
+	const maxAttempts = 8
+	for {
+		outbox.mutex.Lock()
+		delivery.Attempts++
+		attempts := delivery.Attempts
+		outbox.mutex.Unlock()
+
+		err := outbox.client.post(delivery.Target, delivery.Body)
+
+		outbox.mutex.Lock()
+		if err != nil {
+			delivery.LastError = err.Error()
+		} else {
+			delivery.Delivered = true
+			delivery.LastError = ""
+		}
+		snapshot := *delivery
+		outbox.mutex.Unlock()
+
+		if dbErr := db.UpdateResultsPushDelivery(&snapshot); dbErr != nil {
+			log.Printf("Failed to persist results push delivery %d: %s", delivery.Id, dbErr)
+		}
+		outbox.statusNotifier.Notify(snapshot)
+
+		if err == nil {
+			return
+		}
+		if _, permanent := err.(*resultsPushPermanentError); permanent {
+			return
+		}
+		if attempts >= maxAttempts {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// resultsPushClient is a small typed HTTP client for posting a match result body to a target.
+type resultsPushClient struct {
+	httpClient *http.Client
+}
+
+func newResultsPushClient() *resultsPushClient {
+	return &resultsPushClient{httpClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// post delivers body to target with bearer-token auth, returning a *resultsPushPermanentError on a
+// 4xx response (not worth retrying) or a plain error on a 5xx response or transport failure (worth
+// retrying).
+func (client *resultsPushClient) post(target ResultsPushTarget, body []byte) error {
+	request, err := http.NewRequest("POST", target.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if target.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+target.Token)
+	}
+
+	httpClient := client.httpClient
+	if target.InsecureSkipVerify {
+		httpClient = &http.Client{
+			Timeout:   client.httpClient.Timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 500 {
+		return fmt.Errorf("target %s returned %d", target.Name, response.StatusCode)
+	}
+	if response.StatusCode >= 400 {
+		return &resultsPushPermanentError{
+			fmt.Sprintf("target %s rejected the result with %d (not retrying)", target.Name, response.StatusCode),
+		}
+	}
+	return nil
+}
+
+// resultsPushBody is the JSON shape posted to a "generic"-format target.
+type resultsPushBody struct {
+	MatchId          int
+	RedScoreSummary  *ScoreSummary
+	BlueScoreSummary *ScoreSummary
+	RedFouls         []Foul
+	BlueFouls        []Foul
+}
+
+// tbaAllianceResult is one alliance's half of a "tba"-format body.
+type tbaAllianceResult struct {
+	Score *ScoreSummary `json:"score"`
+	Fouls []Foul        `json:"fouls"`
+}
+
+// tbaResultsPushBody mirrors TBA's alliance-keyed match result shape, as opposed to the flat
+// red/blue-prefixed shape the generic and fms formats use.
+type tbaResultsPushBody struct {
+	MatchNumber int `json:"match_number"`
+	Alliances   struct {
+		Red  tbaAllianceResult `json:"red"`
+		Blue tbaAllianceResult `json:"blue"`
+	} `json:"alliances"`
+}
+
+// fmsResultsPushBody mirrors the flat, PascalCase-keyed shape an FMS mirror expects.
+type fmsResultsPushBody struct {
+	MatchId   int           `json:"MatchId"`
+	RedScore  *ScoreSummary `json:"RedScore"`
+	BlueScore *ScoreSummary `json:"BlueScore"`
+	RedFouls  []Foul        `json:"RedFouls"`
+	BlueFouls []Foul        `json:"BlueFouls"`
+}
+
+// buildResultsPushBody shapes the match result according to the target's configured format.
+func buildResultsPushBody(format ResultsPushFormat, matchResult *MatchResult, redSummary,
+	blueSummary *ScoreSummary, redFouls, blueFouls []Foul) ([]byte, error) {
+	switch format {
+	case ResultsPushFormatTba:
+		body := tbaResultsPushBody{MatchNumber: matchResult.MatchId}
+		body.Alliances.Red = tbaAllianceResult{Score: redSummary, Fouls: redFouls}
+		body.Alliances.Blue = tbaAllianceResult{Score: blueSummary, Fouls: blueFouls}
+		return json.Marshal(body)
+	case ResultsPushFormatFms:
+		return json.Marshal(fmsResultsPushBody{
+			MatchId:   matchResult.MatchId,
+			RedScore:  redSummary,
+			BlueScore: blueSummary,
+			RedFouls:  redFouls,
+			BlueFouls: blueFouls,
+		})
+	case ResultsPushFormatGeneric:
+		return json.Marshal(resultsPushBody{
+			MatchId:          matchResult.MatchId,
+			RedScoreSummary:  redSummary,
+			BlueScoreSummary: blueSummary,
+			RedFouls:         redFouls,
+			BlueFouls:        blueFouls,
+		})
+	default:
+		return nil, fmt.Errorf("unknown results push format '%s'", format)
+	}
+}
+
+// resultsPushOutbox is the package-level outbox that listens for posted match results and delivers
+// them to every configured external target. Its construction here is deliberately cheap (no db
+// access, no goroutines) -- call resultsPushOutbox.Start() from the startup path once db is open, not
+// here, since package-level var initializers run before main() and would otherwise nil-deref on the
+// very first process start.
+var resultsPushOutbox = NewResultsPushOutbox(new(Notifier))
+
+// AdminResultsPushStatusWebsocketHandler streams delivery status for queued results-push deliveries
+// so operators can see failed pushes in real time.
+func AdminResultsPushStatusWebsocketHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeRequest(w, r, RoleAdmin); !ok {
+		return
+	}
+
+	websocket, err := NewWebsocket(w, r)
+	if err != nil {
+		handleWebErr(w, err)
+		return
+	}
+	defer websocket.Close()
+
+	statusListener := resultsPushOutbox.statusNotifier.Listen()
+	defer close(statusListener)
+
+	for delivery := range statusListener {
+		if err = websocket.Write("deliveryStatus", delivery); err != nil {
+			return
+		}
+	}
+}
+
+// RegisterResultsPushRoutes wires this file's handler into router. Called from the same startup path
+// that registers the rest of the display routes, after resultsPushOutbox.Start() has been called.
+func RegisterResultsPushRoutes(router *mux.Router) {
+	router.HandleFunc("/api/results_push/status/websocket", AdminResultsPushStatusWebsocketHandler)
+}
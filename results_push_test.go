@@ -0,0 +1,88 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildResultsPushBodyFormatsDiffer(t *testing.T) {
+	matchResult := &MatchResult{MatchId: 42}
+	redFouls := []Foul{{TeamId: 254, Rule: "G1"}}
+	var blueFouls []Foul
+
+	tbaBody, err := buildResultsPushBody(ResultsPushFormatTba, matchResult, nil, nil, redFouls, blueFouls)
+	if err != nil {
+		t.Fatalf("buildResultsPushBody(tba) returned an error: %s", err)
+	}
+	fmsBody, err := buildResultsPushBody(ResultsPushFormatFms, matchResult, nil, nil, redFouls, blueFouls)
+	if err != nil {
+		t.Fatalf("buildResultsPushBody(fms) returned an error: %s", err)
+	}
+	genericBody, err := buildResultsPushBody(ResultsPushFormatGeneric, matchResult, nil, nil, redFouls, blueFouls)
+	if err != nil {
+		t.Fatalf("buildResultsPushBody(generic) returned an error: %s", err)
+	}
+
+	if bytes.Equal(tbaBody, fmsBody) || bytes.Equal(fmsBody, genericBody) || bytes.Equal(tbaBody, genericBody) {
+		t.Fatalf("expected tba, fms, and generic to each produce a distinct payload shape")
+	}
+
+	var tbaDecoded map[string]interface{}
+	if err := json.Unmarshal(tbaBody, &tbaDecoded); err != nil {
+		t.Fatalf("failed to decode tba body: %s", err)
+	}
+	if _, ok := tbaDecoded["alliances"]; !ok {
+		t.Errorf("expected the tba body to have an \"alliances\" key, got %v", tbaDecoded)
+	}
+
+	var fmsDecoded map[string]interface{}
+	if err := json.Unmarshal(fmsBody, &fmsDecoded); err != nil {
+		t.Fatalf("failed to decode fms body: %s", err)
+	}
+	if _, ok := fmsDecoded["RedScore"]; !ok {
+		t.Errorf("expected the fms body to have a \"RedScore\" key, got %v", fmsDecoded)
+	}
+
+	if _, err := buildResultsPushBody("bogus", matchResult, nil, nil, redFouls, blueFouls); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestPostClassifiesStatusCodes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/client-error":
+			w.WriteHeader(http.StatusBadRequest)
+		case "/server-error":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	client := newResultsPushClient()
+
+	if err := client.post(ResultsPushTarget{Name: "t", Url: server.URL + "/ok"}, []byte("{}")); err != nil {
+		t.Errorf("expected no error for a 200 response, got %s", err)
+	}
+
+	err := client.post(ResultsPushTarget{Name: "t", Url: server.URL + "/client-error"}, []byte("{}"))
+	if _, ok := err.(*resultsPushPermanentError); !ok {
+		t.Errorf("expected a *resultsPushPermanentError for a 400 response, got %v (%T)", err, err)
+	}
+
+	err = client.post(ResultsPushTarget{Name: "t", Url: server.URL + "/server-error"}, []byte("{}"))
+	if err == nil {
+		t.Fatal("expected an error for a 500 response")
+	}
+	if _, ok := err.(*resultsPushPermanentError); ok {
+		t.Error("expected a 500 response to be retryable, not a *resultsPushPermanentError")
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2014 Team 254. All Rights Reserved.
+// Author: pat@patfairbank.com (Patrick Fairbank)
+//
+// Point totals for a single alliance's autonomous and teleop performance in a match.
+
+package main
+
+// Score contains the autonomous point-scoring totals and the list of teleop cycles completed by one
+// alliance.
+type Score struct {
+	AutoMobilityBonuses int
+	AutoHighHot         int
+	AutoHigh            int
+	AutoLowHot          int
+	AutoLow             int
+	AutoClearHigh       int
+	AutoClearLow        int
+	AutoClearDead       int
+	Cycles              []Cycle
+}
+
+// Cycle represents a single ball's journey through the teleop truss/catch/score sequence.
+type Cycle struct {
+	ScoredHigh bool
+	ScoredLow  bool
+	DeadBall   bool
+	Assists    int
+	Truss      bool
+	Catch      bool
+}